@@ -0,0 +1,227 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/ip4defrag"
+	"github.com/google/gopacket/layers"
+)
+
+// defaultFragmentTTL is how long an incomplete fragment group is kept
+// around before it is evicted, in case one of its fragments was dropped
+// or never arrives.
+const defaultFragmentTTL = 30 * time.Second
+
+// defragStage sits between the raw packet source and Bonjour filtering,
+// reassembling fragmented IPv4 and IPv6 datagrams before they reach
+// filterBonjourPacketsLazily. Large mDNS answers (a TXT/PTR record set
+// spanning multiple fragments) would otherwise arrive as packets with no
+// complete UDP header to parse.
+//
+// Packets that are not fragments pass through untouched. A fragment that
+// completes its group is forwarded once, re-serialized as a single
+// packet carrying the reassembled payload. A fragment that is still
+// waiting on the rest of its group produces no output at all. Groups
+// older than ttl are evicted so a dropped fragment cannot hold memory
+// forever.
+func defragStage(in <-chan gopacket.Packet, ttl time.Duration) <-chan gopacket.Packet {
+	out := make(chan gopacket.Packet)
+
+	go func() {
+		defer close(out)
+
+		v4Defragmenter := ip4defrag.NewIPv4Defragmenter()
+		v6Defragmenter := newIPv6Defragmenter()
+
+		ticker := time.NewTicker(ttl)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case packet, ok := <-in:
+				if !ok {
+					return
+				}
+				if reassembled := defragPacket(packet, v4Defragmenter, v6Defragmenter); reassembled != nil {
+					out <- reassembled
+				}
+			case now := <-ticker.C:
+				v4Defragmenter.DiscardOlderThan(now.Add(-ttl))
+				v6Defragmenter.discardOlderThan(now.Add(-ttl))
+			}
+		}
+	}()
+
+	return out
+}
+
+// defragPacket feeds packet through the appropriate defragmenter and
+// returns the packet that should be forwarded downstream: packet itself
+// if it was not a fragment, a re-serialized packet once its group is
+// complete, or nil while its group is still incomplete.
+func defragPacket(packet gopacket.Packet, v4Defragmenter *ip4defrag.IPv4Defragmenter, v6Defragmenter *ipv6Defragmenter) gopacket.Packet {
+	if ipv4Layer := packet.Layer(layers.LayerTypeIPv4); ipv4Layer != nil {
+		ipv4 := ipv4Layer.(*layers.IPv4)
+		if ipv4.FragOffset == 0 && ipv4.Flags&layers.IPv4MoreFragments == 0 {
+			return packet
+		}
+
+		reassembled, err := v4Defragmenter.DefragIPv4(ipv4)
+		if err != nil || reassembled == nil {
+			return nil
+		}
+		return rebuildWithIPv4(packet, reassembled)
+	}
+
+	if fragLayer := packet.Layer(layers.LayerTypeIPv6Fragment); fragLayer != nil {
+		ipv6Layer := packet.Layer(layers.LayerTypeIPv6)
+		if ipv6Layer == nil {
+			return nil
+		}
+		ipv6, frag := ipv6Layer.(*layers.IPv6), fragLayer.(*layers.IPv6Fragment)
+
+		payload, done := v6Defragmenter.defrag(ipv6, frag)
+		if !done {
+			return nil
+		}
+		return rebuildWithIPv6Payload(packet, ipv6, payload)
+	}
+
+	return packet
+}
+
+// rebuildWithIPv4 re-serializes packet's layers up to and including the
+// IPv4 header, replaced by reassembled, followed by reassembled.Payload
+// (the UDP datagram that was split across fragments). The layers above
+// IP in packet belong only to its last fragment and are dropped, the
+// same way rebuildWithIPv6Payload drops the Fragment extension header.
+func rebuildWithIPv4(packet gopacket.Packet, reassembled *layers.IPv4) gopacket.Packet {
+	layerList := packet.Layers()
+	serializableLayers := make([]gopacket.SerializableLayer, 0, len(layerList)+1)
+	for _, layer := range layerList {
+		if layer.LayerType() == layers.LayerTypeIPv4 {
+			serializableLayers = append(serializableLayers, reassembled)
+			break
+		}
+		if serializable, ok := layer.(gopacket.SerializableLayer); ok {
+			serializableLayers = append(serializableLayers, serializable)
+		}
+	}
+	serializableLayers = append(serializableLayers, gopacket.Payload(reassembled.Payload))
+	return serializeAsPacket(serializableLayers)
+}
+
+// rebuildWithIPv6Payload re-serializes packet's layers up to and
+// including the IPv6 header, followed by the reassembled payload (the
+// UDP datagram that was split across fragments), dropping the Fragment
+// extension header itself.
+func rebuildWithIPv6Payload(packet gopacket.Packet, ipv6 *layers.IPv6, payload []byte) gopacket.Packet {
+	layerList := packet.Layers()
+	serializableLayers := make([]gopacket.SerializableLayer, 0, len(layerList)+1)
+	for _, layer := range layerList {
+		switch layer.LayerType() {
+		case layers.LayerTypeIPv6:
+			ipv6.NextHeader = layers.IPProtocolUDP
+			serializableLayers = append(serializableLayers, ipv6)
+		case layers.LayerTypeIPv6Fragment, layers.LayerTypeUDP, layers.LayerTypeDNS:
+			// Dropped: replaced wholesale by the reassembled payload below.
+		default:
+			if serializable, ok := layer.(gopacket.SerializableLayer); ok {
+				serializableLayers = append(serializableLayers, serializable)
+			}
+		}
+	}
+	serializableLayers = append(serializableLayers, gopacket.Payload(payload))
+	return serializeAsPacket(serializableLayers)
+}
+
+func serializeAsPacket(serializableLayers []gopacket.SerializableLayer) gopacket.Packet {
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buffer, options, serializableLayers...); err != nil {
+		return nil
+	}
+	decoder := gopacket.DecodersByLayerName["Ethernet"]
+	return gopacket.NewPacket(buffer.Bytes(), decoder, gopacket.DecodeOptions{Lazy: true})
+}
+
+// ipv6FragmentKey identifies the fragment group a given IPv6 fragment
+// belongs to, mirroring the (src, dst, id) key ip4defrag uses for IPv4.
+type ipv6FragmentKey struct {
+	src, dst string
+	id       uint32
+}
+
+type ipv6FragmentPiece struct {
+	offset    int
+	more      bool
+	payload   []byte
+	firstSeen time.Time
+}
+
+// ipv6Defragmenter reassembles IPv6 datagrams split across a Fragment
+// extension header. gopacket ships ip4defrag for IPv4 but has no IPv6
+// equivalent, so this follows the same bookkeeping for the IPv6 case.
+type ipv6Defragmenter struct {
+	groups map[ipv6FragmentKey][]ipv6FragmentPiece
+}
+
+func newIPv6Defragmenter() *ipv6Defragmenter {
+	return &ipv6Defragmenter{groups: make(map[ipv6FragmentKey][]ipv6FragmentPiece)}
+}
+
+// defrag folds in one fragment of ipv6's payload, described by frag, and
+// returns the fully reassembled payload once every fragment of its group
+// has arrived.
+func (d *ipv6Defragmenter) defrag(ipv6 *layers.IPv6, frag *layers.IPv6Fragment) (payload []byte, done bool) {
+	key := ipv6FragmentKey{src: ipv6.SrcIP.String(), dst: ipv6.DstIP.String(), id: frag.Identification}
+
+	d.groups[key] = append(d.groups[key], ipv6FragmentPiece{
+		offset:    int(frag.FragmentOffset) * 8,
+		more:      frag.MoreFragments,
+		payload:   append([]byte(nil), frag.LayerPayload()...),
+		firstSeen: time.Now(),
+	})
+
+	pieces := d.groups[key]
+	haveLastPiece := false
+	for _, piece := range pieces {
+		if !piece.more {
+			haveLastPiece = true
+			break
+		}
+	}
+	if !haveLastPiece {
+		return nil, false
+	}
+
+	sorted := append([]ipv6FragmentPiece(nil), pieces...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].offset < sorted[j].offset })
+
+	reassembled := make([]byte, 0)
+	expectedOffset := 0
+	for _, piece := range sorted {
+		if piece.offset != expectedOffset {
+			// A gap: a fragment in the middle of the group hasn't arrived yet.
+			return nil, false
+		}
+		reassembled = append(reassembled, piece.payload...)
+		expectedOffset += len(piece.payload)
+	}
+
+	delete(d.groups, key)
+	return reassembled, true
+}
+
+// discardOlderThan evicts any fragment group whose first fragment
+// arrived before threshold, so a group missing its last fragment cannot
+// leak memory forever.
+func (d *ipv6Defragmenter) discardOlderThan(threshold time.Time) {
+	for key, pieces := range d.groups {
+		if len(pieces) > 0 && pieces[0].firstSeen.Before(threshold) {
+			delete(d.groups, key)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// dot11ToDS and dot11FromDS mirror the ToDS/FromDS bits of an 802.11
+// frame control field, used to pick apart what Address1/2/3 mean.
+const (
+	dot11ToDS   = 0x1
+	dot11FromDS = 0x2
+)
+
+// parseDot11Addresses extracts the source and destination MAC addresses
+// from an 802.11 data frame. Address1/Address2/Address3 mean different
+// things depending on the ToDS/FromDS flags (802.11-2020 Table 9-26); a
+// WDS frame (both flags set, Address4 present) is not expected on a
+// simple monitor-mode bridge and is treated the same as AP->STA.
+func parseDot11Addresses(dot11 *layers.Dot11) (srcMAC *net.HardwareAddr, dstMAC *net.HardwareAddr) {
+	flags := uint8(dot11.Flags)
+	switch {
+	case flags&dot11ToDS == 0 && flags&dot11FromDS == 0:
+		// IBSS/ad-hoc: Addr1=DA, Addr2=SA, Addr3=BSSID.
+		return &dot11.Address2, &dot11.Address1
+	case flags&dot11ToDS != 0 && flags&dot11FromDS == 0:
+		// STA -> AP: Addr1=BSSID, Addr2=SA, Addr3=DA.
+		return &dot11.Address2, &dot11.Address3
+	default:
+		// AP -> STA, or WDS: Addr1=DA, Addr3=SA.
+		return &dot11.Address3, &dot11.Address1
+	}
+}
+
+// sendBonjourPacketOverDot11 wraps bp's packet, minus its original L2
+// header, in a minimal RadioTap+Dot11+LLC+SNAP header and writes it out
+// through pw, so it can be injected on a Wi-Fi monitor interface. The
+// frame is sent FromDS, as if bridged out of an access point, with brMAC
+// as both transmitter and BSSID.
+//
+// layers.Dot11Data in the gopacket version this repo vendors does not
+// implement SerializeTo, so unlike the ingress decode chain (which needs
+// it to reach LLC), the egress side cannot include a standalone Dot11Data
+// layer; Dot11.Type already identifies this as a data frame, which is
+// all a receiver needs to parse the LLC header that follows.
+func sendBonjourPacketOverDot11(pw packetWriter, bp *bonjourPacket, brMAC net.HardwareAddr) error {
+	_, ipLayer, payload, err := splitPacketLayers(bp.packet)
+	if err != nil {
+		return err
+	}
+
+	dstMAC := net.HardwareAddr{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	if bp.dstMAC != nil {
+		dstMAC = *bp.dstMAC
+	}
+
+	etherType := layers.EthernetTypeIPv4
+	if _, isIPv6 := ipLayer.(*layers.IPv6); isIPv6 {
+		etherType = layers.EthernetTypeIPv6
+	}
+
+	radiotapLayer := &layers.RadioTap{}
+	dot11Layer := &layers.Dot11{
+		Type:     layers.Dot11TypeData,
+		Flags:    layers.Dot11Flags(dot11FromDS),
+		Address1: dstMAC,
+		Address2: brMAC,
+		Address3: brMAC,
+	}
+	llcLayer := &layers.LLC{
+		DSAP:    0xAA,
+		SSAP:    0xAA,
+		Control: 0x03,
+	}
+	snapLayer := &layers.SNAP{
+		OrganizationalCode: []byte{0x00, 0x00, 0x00},
+		Type:               etherType,
+	}
+
+	frameLayers := []gopacket.SerializableLayer{radiotapLayer, dot11Layer, llcLayer, snapLayer, ipLayer, gopacket.Payload(payload)}
+	return writeFrame(pw, frameLayers)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	dot11APMACTest  = net.HardwareAddr{0x02, 0x11, 0x22, 0x33, 0x44, 0x55}
+	dot11STAMACTest = net.HardwareAddr{0x02, 0x66, 0x77, 0x88, 0x99, 0xAA}
+)
+
+// createMockDot11mDNSPacket builds a RadioTap/Dot11(FromDS)/LLC/SNAP/
+// IPv4/UDP/DNS frame, as would be captured on a Wi-Fi monitor interface,
+// carrying an mDNS query from dot11APMACTest to dot11STAMACTest.
+//
+// layers.Dot11Data, which the ingress decode chain needs to reach LLC,
+// contributes no header bytes of its own (its DecodeFromBytes consumes
+// zero bytes and defers straight to LLC), so the frame bytes are
+// identical whether or not it is listed here; it is a non-serializable
+// layer in the gopacket version this repo vendors, so it is left out.
+func createMockDot11mDNSPacket() []byte {
+	radiotapLayer := &layers.RadioTap{}
+	dot11Layer := &layers.Dot11{
+		Type:     layers.Dot11TypeData,
+		Flags:    layers.Dot11Flags(dot11FromDS),
+		Address1: dot11STAMACTest,
+		Address2: dot11APMACTest,
+		Address3: dot11APMACTest,
+	}
+	llcLayer := &layers.LLC{DSAP: 0xAA, SSAP: 0xAA, Control: 0x03}
+	snapLayer := &layers.SNAP{OrganizationalCode: []byte{0x00, 0x00, 0x00}, Type: layers.EthernetTypeIPv4}
+	ipLayer := &layers.IPv4{
+		SrcIP:    srcIPv4Test,
+		DstIP:    dstIPv4Test,
+		Version:  4,
+		Protocol: layers.IPProtocolUDP,
+		IHL:      5,
+	}
+	udpLayer := &layers.UDP{SrcPort: srcUDPPortTest, DstPort: dstUDPPortTest}
+	dnsLayer := &layers.DNS{
+		Questions: []layers.DNSQuestion{{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+		QDCount:   1,
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{},
+		radiotapLayer, dot11Layer, llcLayer, snapLayer, ipLayer, udpLayer, dnsLayer)
+	return buffer.Bytes()
+}
+
+func TestParseDot11Addresses(t *testing.T) {
+	dot11Layer := &layers.Dot11{
+		Flags:    layers.Dot11Flags(dot11FromDS),
+		Address1: dot11STAMACTest,
+		Address2: dot11APMACTest,
+		Address3: dot11APMACTest,
+	}
+
+	srcMAC, dstMAC := parseDot11Addresses(dot11Layer)
+	if srcMAC.String() != dot11APMACTest.String() {
+		t.Errorf("parseDot11Addresses() srcMAC = %v, want %v", srcMAC, dot11APMACTest)
+	}
+	if dstMAC.String() != dot11STAMACTest.String() {
+		t.Errorf("parseDot11Addresses() dstMAC = %v, want %v", dstMAC, dot11STAMACTest)
+	}
+}
+
+func TestFilterBonjourPacketsLazilyOverDot11(t *testing.T) {
+	decoder := gopacket.DecodersByLayerName["RadioTap"]
+	data := createMockDot11mDNSPacket()
+
+	in := make(chan gopacket.Packet, 1)
+	in <- gopacket.NewPacket(data, decoder, gopacket.DecodeOptions{Lazy: true})
+	close(in)
+
+	packetChan := filterBonjourPacketsLazily(in, brMACTest, layers.LayerTypeRadioTap)
+
+	result, ok := <-packetChan
+	if !ok {
+		t.Fatal("filterBonjourPacketsLazily() did not forward a Bonjour query carried over 802.11")
+	}
+	if result.srcMAC.String() != dot11APMACTest.String() {
+		t.Errorf("srcMAC = %v, want %v", result.srcMAC, dot11APMACTest)
+	}
+	if !result.isDNSQuery {
+		t.Error("isDNSQuery = false, want true")
+	}
+}
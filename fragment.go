@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// defaultMTU is the MTU assumed for an output interface when none is
+// configured explicitly.
+const defaultMTU = 1500
+
+// splitPacketLayers splits packet's layers into the ones that come
+// before its IP layer (Ethernet, and Dot1Q if present), the IP layer
+// itself, and the serialized bytes of everything after it.
+func splitPacketLayers(packet gopacket.Packet) (l2Layers []gopacket.SerializableLayer, ipLayer gopacket.SerializableLayer, payload []byte, err error) {
+	layerList := packet.Layers()
+
+	i := 0
+	for ; i < len(layerList); i++ {
+		layerType := layerList[i].LayerType()
+		if layerType == layers.LayerTypeIPv4 || layerType == layers.LayerTypeIPv6 {
+			break
+		}
+		if serializable, ok := layerList[i].(gopacket.SerializableLayer); ok {
+			l2Layers = append(l2Layers, serializable)
+		}
+	}
+	if i == len(layerList) {
+		return nil, nil, nil, fmt.Errorf("bonjour-reflector: packet has neither an IPv4 nor IPv6 layer")
+	}
+
+	ipLayer, ok := layerList[i].(gopacket.SerializableLayer)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("bonjour-reflector: IP layer %T is not serializable", layerList[i])
+	}
+
+	var aboveIP []gopacket.SerializableLayer
+	for _, layer := range layerList[i+1:] {
+		if serializable, ok := layer.(gopacket.SerializableLayer); ok {
+			aboveIP = append(aboveIP, serializable)
+		}
+	}
+	payload, err = serializeLayers(aboveIP...)
+	return
+}
+
+func serializeLayers(serializableLayers ...gopacket.SerializableLayer) ([]byte, error) {
+	buffer := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{}, serializableLayers...); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), buffer.Bytes()...), nil
+}
+
+func writeFrame(pw packetWriter, frameLayers []gopacket.SerializableLayer) error {
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buffer, options, frameLayers...); err != nil {
+		return err
+	}
+	return pw.WritePacketData(buffer.Bytes())
+}
+
+// ipHeaderSize returns the serialized size, in bytes, of ipLayer's
+// header, including any IPv4 options.
+func ipHeaderSize(ipLayer gopacket.SerializableLayer) (int, error) {
+	switch ip := ipLayer.(type) {
+	case *layers.IPv4:
+		size := int(ip.IHL) * 4
+		if size < 20 {
+			size = 20
+		}
+		return size, nil
+	case *layers.IPv6:
+		return 40, nil
+	default:
+		return 0, fmt.Errorf("bonjour-reflector: unsupported IP layer type %T", ipLayer)
+	}
+}
+
+// fragmentAndSend splits payload, the bytes following ipLayer, into
+// fragments small enough that each resulting IP datagram (IP header,
+// any fragment header, and payload chunk) fits within mtu, and
+// writes one frame per fragment through pw, each wrapped in the same
+// l2Layers header. IPv4 is fragmented per RFC 791 (FragOffset/MoreFrags
+// on the IPv4 header itself, IP options preserved in every fragment);
+// IPv6 gets a Fragment extension header prepended to each fragment,
+// since the IPv6 header itself carries no fragmentation fields.
+func fragmentAndSend(pw packetWriter, l2Layers []gopacket.SerializableLayer, ipLayer gopacket.SerializableLayer, payload []byte, mtu int) error {
+	switch ip := ipLayer.(type) {
+	case *layers.IPv4:
+		return fragmentAndSendIPv4(pw, l2Layers, ip, payload, mtu)
+	case *layers.IPv6:
+		return fragmentAndSendIPv6(pw, l2Layers, ip, payload, mtu)
+	default:
+		return fmt.Errorf("bonjour-reflector: unsupported IP layer type %T", ipLayer)
+	}
+}
+
+func fragmentAndSendIPv4(pw packetWriter, l2Layers []gopacket.SerializableLayer, ip4 *layers.IPv4, payload []byte, mtu int) error {
+	headerSize, err := ipHeaderSize(ip4)
+	if err != nil {
+		return err
+	}
+
+	// Every fragment but the last must carry a payload that is a multiple
+	// of 8 bytes, since FragOffset is expressed in 8-byte units.
+	maxChunk := (mtu - headerSize) &^ 7
+	if maxChunk <= 0 {
+		return fmt.Errorf("bonjour-reflector: MTU %d is too small for a %d-byte IPv4 header", mtu, headerSize)
+	}
+
+	for offset := 0; offset < len(payload); offset += maxChunk {
+		end := offset + maxChunk
+		if end > len(payload) {
+			end = len(payload)
+		}
+
+		fragment := *ip4
+		fragment.FragOffset = uint16(offset / 8)
+		fragment.Flags = ip4.Flags &^ layers.IPv4DontFragment
+		if end < len(payload) {
+			fragment.Flags |= layers.IPv4MoreFragments
+		} else {
+			fragment.Flags &^= layers.IPv4MoreFragments
+		}
+
+		frameLayers := append(append([]gopacket.SerializableLayer{}, l2Layers...), &fragment, gopacket.Payload(payload[offset:end]))
+		if err := writeFrame(pw, frameLayers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	ipv6HeaderSize     = 40
+	ipv6FragHeaderSize = 8
+)
+
+func fragmentAndSendIPv6(pw packetWriter, l2Layers []gopacket.SerializableLayer, ip6 *layers.IPv6, payload []byte, mtu int) error {
+	maxChunk := (mtu - ipv6HeaderSize - ipv6FragHeaderSize) &^ 7
+	if maxChunk <= 0 {
+		return fmt.Errorf("bonjour-reflector: MTU %d is too small for an IPv6 header plus fragment header", mtu)
+	}
+
+	originalNextHeader := ip6.NextHeader
+	identification := uint32(time.Now().UnixNano())
+
+	for offset := 0; offset < len(payload); offset += maxChunk {
+		end := offset + maxChunk
+		if end > len(payload) {
+			end = len(payload)
+		}
+
+		fragment := *ip6
+		fragment.NextHeader = layers.IPProtocolIPv6Fragment
+
+		fragHeader := buildIPv6FragmentHeader(originalNextHeader, uint16(offset/8), end < len(payload), identification)
+		fragmentPayload := append(fragHeader, payload[offset:end]...)
+
+		frameLayers := append(append([]gopacket.SerializableLayer{}, l2Layers...), &fragment, gopacket.Payload(fragmentPayload))
+		if err := writeFrame(pw, frameLayers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildIPv6FragmentHeader hand-serializes an IPv6 Fragment extension
+// header (RFC 8200 §4.5): NextHeader, a reserved byte, a 13-bit fragment
+// offset (in 8-byte units) packed with 2 reserved bits and the M flag,
+// and a 32-bit identification. layers.IPv6Fragment in the gopacket
+// version this repo vendors does not implement SerializeTo, so this
+// cannot be built as a gopacket.SerializableLayer and is instead
+// prepended directly to the fragment's payload bytes.
+func buildIPv6FragmentHeader(nextHeader layers.IPProtocol, fragOffsetIn8ByteUnits uint16, moreFragments bool, identification uint32) []byte {
+	header := make([]byte, ipv6FragHeaderSize)
+	header[0] = byte(nextHeader)
+	header[1] = 0
+
+	offsetAndFlags := fragOffsetIn8ByteUnits << 3
+	if moreFragments {
+		offsetAndFlags |= 0x1
+	}
+	binary.BigEndian.PutUint16(header[2:4], offsetAndFlags)
+	binary.BigEndian.PutUint32(header[4:8], identification)
+
+	return header
+}
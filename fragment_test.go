@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// createOversizeAnswerPacket builds a raw Ethernet/Dot1Q/IPv4/UDP/DNS
+// packet carrying many A records, large enough that its payload will not
+// fit in a single frame at the small test MTU used below.
+func createOversizeAnswerPacket(t *testing.T) []byte {
+	t.Helper()
+
+	answers := make([]layers.DNSResourceRecord, 0, 40)
+	for i := 0; i < 40; i++ {
+		answers = append(answers, layers.DNSResourceRecord{
+			Name:  []byte("example.com"),
+			Type:  layers.DNSTypeA,
+			Class: layers.DNSClassIN,
+			TTL:   120,
+			IP:    net.IP{10, 0, byte(i / 256), byte(i % 256)},
+		})
+	}
+
+	ethernetLayer := &layers.Ethernet{
+		SrcMAC:       srcMACTest,
+		DstMAC:       dstMACTest,
+		EthernetType: layers.EthernetTypeDot1Q,
+	}
+	dot1QLayer := &layers.Dot1Q{
+		VLANIdentifier: vlanIdentifierTest,
+		Type:           layers.EthernetTypeIPv4,
+	}
+	ipLayer := &layers.IPv4{
+		SrcIP:    srcIPv4Test,
+		DstIP:    dstIPv4Test,
+		Version:  4,
+		Protocol: layers.IPProtocolUDP,
+		IHL:      5,
+	}
+	udpLayer := &layers.UDP{
+		SrcPort: srcUDPPortTest,
+		DstPort: dstUDPPortTest,
+	}
+	dnsLayer := &layers.DNS{
+		Answers: answers,
+		ANCount: uint16(len(answers)),
+		QR:      true,
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{},
+		ethernetLayer, dot1QLayer, ipLayer, udpLayer, dnsLayer); err != nil {
+		t.Fatalf("failed to build the oversize test packet: %v", err)
+	}
+	return buffer.Bytes()
+}
+
+func TestSendBonjourPacketFragmentsOversizePackets(t *testing.T) {
+	const testMTU = 100
+
+	raw := createOversizeAnswerPacket(t)
+	decoder := gopacket.DecodersByLayerName["Ethernet"]
+	initialPacket := gopacket.NewPacket(raw, decoder, gopacket.DecodeOptions{Lazy: true})
+	wantPayload, err := serializeLayers(serializableLayers(initialPacket)[3:]...)
+	if err != nil {
+		t.Fatalf("failed to compute the expected reassembled payload: %v", err)
+	}
+
+	bp := bonjourPacket{
+		packet:     initialPacket,
+		vlanTag:    &vlanIdentifierTest,
+		srcMAC:     &srcMACTest,
+		dstMAC:     &dstMACTest,
+		isDNSQuery: false,
+	}
+
+	pw := &mockPacketWriter{}
+	if err := sendBonjourPacket(pw, &bp, vlanIdentifierTest, brMACTest, testMTU); err != nil {
+		t.Fatalf("sendBonjourPacket() returned an unexpected error: %v", err)
+	}
+
+	if len(pw.packets) < 2 {
+		t.Fatalf("sendBonjourPacket() wrote %d packets for an oversize payload, want more than 1", len(pw.packets))
+	}
+
+	type fragment struct {
+		offset  int
+		more    bool
+		payload []byte
+	}
+	fragments := make([]fragment, 0, len(pw.packets))
+	for _, packet := range pw.packets {
+		ipLayer := packet.Layer(layers.LayerTypeIPv4)
+		if ipLayer == nil {
+			t.Fatal("a fragment written by sendBonjourPacket has no IPv4 layer")
+		}
+		ip4 := ipLayer.(*layers.IPv4)
+		fragments = append(fragments, fragment{
+			offset:  int(ip4.FragOffset) * 8,
+			more:    ip4.Flags&layers.IPv4MoreFragments != 0,
+			payload: append([]byte(nil), ip4.LayerPayload()...),
+		})
+	}
+
+	sort.Slice(fragments, func(i, j int) bool { return fragments[i].offset < fragments[j].offset })
+
+	var reassembled bytes.Buffer
+	expectedOffset := 0
+	for i, frag := range fragments {
+		if frag.offset != expectedOffset {
+			t.Fatalf("fragment %d starts at offset %d, want %d (a gap or overlap)", i, frag.offset, expectedOffset)
+		}
+		isLast := i == len(fragments)-1
+		if frag.more == isLast {
+			t.Errorf("fragment %d has MoreFragments=%v, want %v", i, frag.more, !isLast)
+		}
+		reassembled.Write(frag.payload)
+		expectedOffset += len(frag.payload)
+	}
+
+	if !bytes.Equal(reassembled.Bytes(), wantPayload) {
+		t.Error("reassembling sendBonjourPacket()'s fragments did not reproduce the original payload byte-for-byte")
+	}
+}
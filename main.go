@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+func main() {
+	interfaceName := flag.String("interface", "", "name of the trunk interface to listen on and reflect Bonjour traffic through")
+	sourceType := flag.String("source-type", "ethernet", `how to interpret packets read from -interface: "ethernet" for a wired VLAN trunk, or "dot11" for a Wi-Fi interface in monitor mode`)
+	bridgeMACStr := flag.String("bridge-mac", "", "MAC address of the bridge, used to avoid reflecting our own packets")
+	snaplen := flag.Int("snaplen", 1600, "maximum number of bytes to read per packet")
+	vxlanInterfaceName := flag.String("vxlan-interface", "", "name of the interface to send/receive VXLAN-encapsulated Bonjour traffic on, for peers that are not reachable as a plain VLAN trunk")
+	vxlanLocalIPStr := flag.String("vxlan-local-ip", "", "local IP address to use as the VTEP source address")
+	vxlanPeersSpec := flag.String("vxlan-peers", "", "comma-separated list of vlan:vni:remoteIP:vtepMAC entries describing remote VTEPs to bridge Bonjour traffic to")
+	mtu := flag.Int("mtu", defaultMTU, "MTU of the output interface; larger reflected packets are fragmented to fit")
+	dot11VLAN := flag.Uint("dot11-vlan", 0, "VLAN tag to assume for Bonjour packets received on a dot11 source, which carry no 802.1Q tag of their own")
+	flag.Parse()
+
+	if *interfaceName == "" {
+		log.Fatal("missing required -interface flag")
+	}
+
+	isDot11 := *sourceType == "dot11"
+	startLayer := layers.LayerTypeEthernet
+	if isDot11 {
+		startLayer = layers.LayerTypeRadioTap
+	}
+
+	brMAC, err := net.ParseMAC(*bridgeMACStr)
+	if err != nil {
+		log.Fatalf("invalid -bridge-mac: %v", err)
+	}
+
+	vxlanPeers, err := parseVXLANPeers(*vxlanPeersSpec)
+	if err != nil {
+		log.Fatalf("invalid -vxlan-peers: %v", err)
+	}
+
+	handle, err := pcap.OpenLive(*interfaceName, int32(*snaplen), true, pcap.BlockForever)
+	if err != nil {
+		log.Fatalf("could not open interface %s: %v", *interfaceName, err)
+	}
+	defer handle.Close()
+	if isDot11 {
+		if err := handle.SetLinkType(layers.LinkTypeIEEE80211Radio); err != nil {
+			log.Fatalf("could not switch %s into 802.11 monitor mode: %v", *interfaceName, err)
+		}
+	}
+
+	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
+	rawPackets := make(chan gopacket.Packet)
+	go func() {
+		for packet := range packetSource.Packets() {
+			rawPackets <- packet
+		}
+	}()
+
+	var vxlanHandle *pcap.Handle
+	var vxlanLocalIP net.IP
+	if *vxlanInterfaceName != "" {
+		vxlanHandle, err = pcap.OpenLive(*vxlanInterfaceName, int32(*snaplen), true, pcap.BlockForever)
+		if err != nil {
+			log.Fatalf("could not open VXLAN interface %s: %v", *vxlanInterfaceName, err)
+		}
+		defer vxlanHandle.Close()
+
+		vxlanLocalIP = net.ParseIP(*vxlanLocalIPStr)
+		if vxlanLocalIP == nil {
+			log.Fatal("missing or invalid -vxlan-local-ip, required when -vxlan-interface is set")
+		}
+
+		vxlanSource := gopacket.NewPacketSource(vxlanHandle, vxlanHandle.LinkType())
+		vxlanIngressStage(vxlanSource.Packets(), rawPackets)
+	}
+
+	defragged := defragStage(rawPackets, defaultFragmentTTL)
+	packetChan := filterBonjourPacketsLazily(defragged, brMAC, startLayer)
+
+	for bonjourPacket := range packetChan {
+		vlanTag := bonjourPacket.vlanTag
+		if vlanTag == nil {
+			// dot11 frames carry no 802.1Q tag of their own; fall back to
+			// the VLAN configured for this source, if any.
+			if !isDot11 || *dot11VLAN == 0 {
+				continue
+			}
+			tag := uint16(*dot11VLAN)
+			vlanTag = &tag
+		}
+
+		if peer, ok := vxlanPeers[*vlanTag]; ok {
+			if err := sendBonjourPacketOverVXLAN(vxlanHandle, &bonjourPacket, peer, vxlanLocalIP, brMAC); err != nil {
+				log.Printf("error reflecting Bonjour packet over VXLAN: %v", err)
+			}
+			continue
+		}
+
+		if isDot11 {
+			if err := sendBonjourPacketOverDot11(handle, &bonjourPacket, brMAC); err != nil {
+				log.Printf("error reflecting Bonjour packet over dot11: %v", err)
+			}
+			continue
+		}
+
+		if err := sendBonjourPacket(handle, &bonjourPacket, *vlanTag, brMAC, *mtu); err != nil {
+			log.Printf("error reflecting Bonjour packet: %v", err)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"net"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	mdnsIPv4 = net.IPv4(224, 0, 0, 251).To4()
+	mdnsIPv6 = net.ParseIP("ff02::fb")
+	mdnsPort = layers.UDPPort(5353)
+)
+
+// bonjourPacket holds the pieces of a decoded Bonjour packet that the
+// reflector needs in order to decide where to forward it and how to
+// retag it before doing so.
+type bonjourPacket struct {
+	packet     gopacket.Packet
+	vlanTag    *uint16
+	srcMAC     *net.HardwareAddr
+	dstMAC     *net.HardwareAddr
+	isDNSQuery bool
+}
+
+// bonjourDecoder bundles a gopacket.DecodingLayerParser with the layer
+// structs it decodes into. One instance is created per reader goroutine
+// and reused across every packet that goroutine handles, so decoding a
+// packet does not allocate a single layer wrapper.
+type bonjourDecoder struct {
+	eth       layers.Ethernet
+	dot1q     layers.Dot1Q
+	radiotap  layers.RadioTap
+	dot11     layers.Dot11
+	dot11Data layers.Dot11Data
+	llc       layers.LLC
+	snap      layers.SNAP
+	ip4       layers.IPv4
+	ip6       layers.IPv6
+	udp       layers.UDP
+	dns       layers.DNS
+	payload   gopacket.Payload
+
+	parser  *gopacket.DecodingLayerParser
+	decoded []gopacket.LayerType
+}
+
+// newBonjourDecoder builds a bonjourDecoder whose DecodingLayerParser
+// starts at startLayer. Pass layers.LayerTypeEthernet for a wired trunk
+// interface, or layers.LayerTypeRadioTap for a Wi-Fi interface opened in
+// monitor mode.
+func newBonjourDecoder(startLayer gopacket.LayerType) *bonjourDecoder {
+	d := &bonjourDecoder{decoded: make([]gopacket.LayerType, 0, 10)}
+	if startLayer == layers.LayerTypeRadioTap {
+		d.parser = gopacket.NewDecodingLayerParser(
+			layers.LayerTypeRadioTap,
+			&d.radiotap, &d.dot11, &d.dot11Data, &d.llc, &d.snap, &d.ip4, &d.ip6, &d.udp, &d.dns, &d.payload,
+		)
+	} else {
+		d.parser = gopacket.NewDecodingLayerParser(
+			layers.LayerTypeEthernet,
+			&d.eth, &d.dot1q, &d.ip4, &d.ip6, &d.udp, &d.dns, &d.payload,
+		)
+	}
+	d.parser.IgnoreUnsupported = true
+	return d
+}
+
+func (d *bonjourDecoder) has(layerType gopacket.LayerType) bool {
+	for _, decodedType := range d.decoded {
+		if decodedType == layerType {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEthernetLayer extracts the source and destination MAC addresses
+// decoded by d, whether d decoded a wired Ethernet frame or an 802.11
+// frame received on a Wi-Fi monitor interface.
+func parseEthernetLayer(d *bonjourDecoder) (srcMAC *net.HardwareAddr, dstMAC *net.HardwareAddr) {
+	if d.has(layers.LayerTypeEthernet) {
+		return &d.eth.SrcMAC, &d.eth.DstMAC
+	}
+	if d.has(layers.LayerTypeDot11) {
+		return parseDot11Addresses(&d.dot11)
+	}
+	return nil, nil
+}
+
+// parseVLANTag extracts the 802.1Q VLAN identifier decoded by d, if any.
+func parseVLANTag(d *bonjourDecoder) (vlanTag *uint16) {
+	if !d.has(layers.LayerTypeDot1Q) {
+		return nil
+	}
+	return &d.dot1q.VLANIdentifier
+}
+
+// parseIPLayer extracts the destination IP address decoded by d, and
+// reports whether it is IPv6.
+func parseIPLayer(d *bonjourDecoder) (dstIP net.IP, isIPv6 bool) {
+	if d.has(layers.LayerTypeIPv4) {
+		return d.ip4.DstIP, false
+	}
+	if d.has(layers.LayerTypeIPv6) {
+		return d.ip6.DstIP, true
+	}
+	return nil, false
+}
+
+// parseUDPLayer extracts the destination UDP port decoded by d.
+func parseUDPLayer(d *bonjourDecoder) (dstPort layers.UDPPort) {
+	if !d.has(layers.LayerTypeUDP) {
+		return 0
+	}
+	return d.udp.DstPort
+}
+
+// parseDNSPayload reports whether the DNS message carried by d's UDP
+// payload is a query (as opposed to an answer).
+//
+// gopacket only binds LayerTypeDNS to UDP port 53 (see
+// layers.UDPPortNames), so the DecodingLayerParser never decodes a DNS
+// layer for mDNS traffic on port 5353 and d.dns is never populated by the
+// chain itself. d.udp.Payload holds the raw bytes regardless, so the DNS
+// message is decoded explicitly from there, into d's own scratch layer
+// to stay allocation-free.
+func parseDNSPayload(d *bonjourDecoder) (isDNSQuery bool) {
+	if !d.has(layers.LayerTypeUDP) {
+		return false
+	}
+	if err := d.dns.DecodeFromBytes(d.udp.Payload, gopacket.NilDecodeFeedback); err != nil {
+		return false
+	}
+	return !d.dns.QR
+}
+
+// filterBonjourPacketsLazily reads packets from packets and forwards the
+// ones that look like Bonjour (mDNS) traffic on packetChan, skipping
+// anything that originated from brMAC to avoid reflecting our own output.
+// packets is typically a packetSource.Packets() channel, possibly routed
+// through defragStage first so that fragmented mDNS answers are whole by
+// the time they reach this filter. startLayer selects how packets is
+// decoded: layers.LayerTypeEthernet for a wired trunk, or
+// layers.LayerTypeRadioTap for a Wi-Fi monitor interface.
+//
+// Filtering decodes each packet's raw bytes into a single bonjourDecoder
+// owned by this goroutine instead of calling packet.Layer, so the filter
+// itself allocates no layer wrapper. Packets that pass every filter are
+// forwarded as the gopacket.Packet they arrived as, for downstream
+// re-serialization.
+func filterBonjourPacketsLazily(packets <-chan gopacket.Packet, brMAC net.HardwareAddr, startLayer gopacket.LayerType) chan bonjourPacket {
+	packetChan := make(chan bonjourPacket)
+
+	go func() {
+		defer close(packetChan)
+
+		decoder := newBonjourDecoder(startLayer)
+
+		for packet := range packets {
+			decoder.decoded = decoder.decoded[:0]
+			if err := decoder.parser.DecodeLayers(packet.Data(), &decoder.decoded); err != nil {
+				// Truncated or otherwise malformed packet; DecodeLayers may
+				// still have populated some layers before failing, but we
+				// have no use for a partially-decoded packet.
+				continue
+			}
+
+			srcMAC, dstMAC := parseEthernetLayer(decoder)
+			if srcMAC == nil || srcMAC.String() == brMAC.String() {
+				continue
+			}
+
+			dstIP, _ := parseIPLayer(decoder)
+			if dstIP == nil || !(dstIP.Equal(mdnsIPv4) || dstIP.Equal(mdnsIPv6)) {
+				continue
+			}
+
+			if parseUDPLayer(decoder) != mdnsPort {
+				continue
+			}
+
+			// Everything from here on is copied off of decoder rather than
+			// referenced from it: decoder's fields are reused for the next
+			// packet as soon as this one is handed off, so any pointer into
+			// it would dangle the moment the goroutine loops around.
+			vlanTag := parseVLANTag(decoder)
+			var vlanTagCopy *uint16
+			if vlanTag != nil {
+				copied := *vlanTag
+				vlanTagCopy = &copied
+			}
+			srcMACCopy := append(net.HardwareAddr(nil), *srcMAC...)
+			dstMACCopy := append(net.HardwareAddr(nil), *dstMAC...)
+
+			packetChan <- bonjourPacket{
+				packet:     packet,
+				vlanTag:    vlanTagCopy,
+				srcMAC:     &srcMACCopy,
+				dstMAC:     &dstMACCopy,
+				isDNSQuery: parseDNSPayload(decoder),
+			}
+		}
+	}()
+
+	return packetChan
+}
+
+// packetWriter is satisfied by pcap.Handle and lets us swap in a mock
+// writer in tests.
+type packetWriter interface {
+	WritePacketData(data []byte) error
+}
+
+// sendBonjourPacket retags bp's packet with vlanTag, rewrites its source
+// MAC to brMAC, and writes the resulting frame out through pw. If the
+// packet's IP payload would exceed mtu, it is split into multiple IPv4 or
+// IPv6 fragments instead, each wrapped in the same L2 header.
+func sendBonjourPacket(pw packetWriter, bp *bonjourPacket, vlanTag uint16, brMAC net.HardwareAddr, mtu int) error {
+	l2Layers, ipLayer, payload, err := splitPacketLayers(bp.packet)
+	if err != nil {
+		return err
+	}
+
+	for i, layer := range l2Layers {
+		switch l := layer.(type) {
+		case *layers.Ethernet:
+			rewritten := *l
+			rewritten.SrcMAC = brMAC
+			l2Layers[i] = &rewritten
+		case *layers.Dot1Q:
+			retagged := *l
+			retagged.VLANIdentifier = vlanTag
+			l2Layers[i] = &retagged
+		}
+	}
+
+	headerSize, err := ipHeaderSize(ipLayer)
+	if err != nil {
+		return err
+	}
+
+	if headerSize+len(payload) <= mtu {
+		frameLayers := append(append([]gopacket.SerializableLayer{}, l2Layers...), ipLayer, gopacket.Payload(payload))
+		return writeFrame(pw, frameLayers)
+	}
+
+	return fragmentAndSend(pw, l2Layers, ipLayer, payload, mtu)
+}
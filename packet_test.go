@@ -114,90 +114,78 @@ func createRawPacket(isIPv4 bool, isDNSQuery bool, dstIP net.IP, srcMAC net.Hard
 	return buffer.Bytes()
 }
 
-func TestParseEthernetLayer(t *testing.T) {
-	decoder := gopacket.DecodersByLayerName["Ethernet"]
-	options := gopacket.DecodeOptions{Lazy: true}
+// decodeForTest runs data through a fresh bonjourDecoder, the same way
+// filterBonjourPacketsLazily does for every packet it reads.
+func decodeForTest(t *testing.T, data []byte) *bonjourDecoder {
+	t.Helper()
+	decoder := newBonjourDecoder(layers.LayerTypeEthernet)
+	if err := decoder.parser.DecodeLayers(data, &decoder.decoded); err != nil {
+		t.Fatalf("DecodeLayers() returned an unexpected error: %v", err)
+	}
+	return decoder
+}
 
-	packet := gopacket.NewPacket(createMockmDNSPacket(true, true), decoder, options)
+func TestParseEthernetLayer(t *testing.T) {
+	decoder := decodeForTest(t, createMockmDNSPacket(true, true))
 
 	expectedResult1, expectedResult2 := &srcMACTest, &dstMACTest
-	computedResult1, computedResult2 := parseEthernetLayer(packet)
+	computedResult1, computedResult2 := parseEthernetLayer(decoder)
 	if !reflect.DeepEqual(expectedResult1, computedResult1) || !reflect.DeepEqual(expectedResult2, computedResult2) {
 		t.Error("Error in parseEthernetLayer()")
 	}
 }
 
 func TestParseVLANTag(t *testing.T) {
-	decoder := gopacket.DecodersByLayerName["Ethernet"]
-	options := gopacket.DecodeOptions{Lazy: true}
+	decoder := decodeForTest(t, createMockmDNSPacket(true, true))
 
-	packet := gopacket.NewPacket(createMockmDNSPacket(true, true), decoder, options)
-
-	expectedLayer := &layers.Dot1Q{
-		VLANIdentifier: vlanIdentifierTest,
-		Type:           layers.EthernetTypeIPv4,
-	}
-	expectedResult := &expectedLayer.VLANIdentifier
-	computedResult := parseVLANTag(packet)
+	expectedResult := &vlanIdentifierTest
+	computedResult := parseVLANTag(decoder)
 	if !reflect.DeepEqual(expectedResult, computedResult) {
-		t.Error("Error in parseEthernetLayer()")
+		t.Error("Error in parseVLANTag()")
 	}
 }
 
 func TestParseIPLayer(t *testing.T) {
-	decoder := gopacket.DecodersByLayerName["Ethernet"]
-	options := gopacket.DecodeOptions{Lazy: true}
-
 	isIPv4 := true
-	ipv4Packet := gopacket.NewPacket(createMockmDNSPacket(isIPv4, true), decoder, options)
+	ipv4Decoder := decodeForTest(t, createMockmDNSPacket(isIPv4, true))
 
-	computedIPv4, computedIsIPv6 := parseIPLayer(ipv4Packet)
+	computedIPv4, computedIsIPv6 := parseIPLayer(ipv4Decoder)
 	if !reflect.DeepEqual(dstIPv4Test, computedIPv4) || (computedIsIPv6 == isIPv4) {
 		t.Error("Error in parseIPLayer() for IPv4 addresses")
 	}
 
 	isIPv4 = false
-	ipv6Packet := gopacket.NewPacket(createMockmDNSPacket(isIPv4, true), decoder, options)
+	ipv6Decoder := decodeForTest(t, createMockmDNSPacket(isIPv4, true))
 
-	computedIPv6, computedIsIPv6 := parseIPLayer(ipv6Packet)
+	computedIPv6, computedIsIPv6 := parseIPLayer(ipv6Decoder)
 	if !reflect.DeepEqual(dstIPv6Test, computedIPv6) || (computedIsIPv6 == isIPv4) {
 		t.Error("Error in parseIPLayer() for IPv6 addresses")
 	}
 }
 
 func TestParseUDPLayer(t *testing.T) {
-	decoder := gopacket.DecodersByLayerName["Ethernet"]
-	options := gopacket.DecodeOptions{Lazy: true}
-
-	packet := gopacket.NewPacket(createMockmDNSPacket(true, true), decoder, options)
+	decoder := decodeForTest(t, createMockmDNSPacket(true, true))
 
 	expectedResult := dstUDPPortTest
-	computedResult, _ := parseUDPLayer(packet)
+	computedResult := parseUDPLayer(decoder)
 	if !reflect.DeepEqual(expectedResult, computedResult) {
 		t.Error("Error in parseUDPLayer()")
 	}
 }
 
 func TestParseDNSPayload(t *testing.T) {
-	decoder := gopacket.DecodersByLayerName["Ethernet"]
-	options := gopacket.DecodeOptions{Lazy: true}
-
-	questionPacket := gopacket.NewPacket(createMockmDNSPacket(true, true), decoder, options)
-
-	_, questionPacketPayload := parseUDPLayer(questionPacket)
+	questionDecoder := decodeForTest(t, createMockmDNSPacket(true, true))
 
 	questionExpectedResult := true
-	questionComputedResult := parseDNSPayload(questionPacketPayload)
+	questionComputedResult := parseDNSPayload(questionDecoder)
 	if !reflect.DeepEqual(questionExpectedResult, questionComputedResult) {
 		t.Error("Error in parseDNSPayload() for DNS queries")
 	}
 
-	answerPacket := gopacket.NewPacket(createMockmDNSPacket(true, false), decoder, options)
-
-	_, answerPacketPayload := parseUDPLayer(answerPacket)
+	answerDecoder := decodeForTest(t, createMockmDNSPacket(true, false))
 
 	answerExpectedResult := false
-	answerComputedResult := parseDNSPayload(answerPacketPayload)
+	answerComputedResult := parseDNSPayload(answerDecoder)
 	if !reflect.DeepEqual(answerExpectedResult, answerComputedResult) {
 		t.Error("Error in parseDNSPayload() for DNS answers")
 	}
@@ -257,7 +245,7 @@ func areBonjourPacketsEqual(a, b bonjourPacket) (areEqual bool) {
 
 func TestFilterBonjourPacketsLazily(t *testing.T) {
 	mockPacketSource, packet := createMockPacketSource()
-	packetChan := filterBonjourPacketsLazily(mockPacketSource, brMACTest)
+	packetChan := filterBonjourPacketsLazily(mockPacketSource.Packets(), brMACTest, layers.LayerTypeEthernet)
 
 	expectedResult := bonjourPacket{
 		packet:     packet,
@@ -272,13 +260,17 @@ func TestFilterBonjourPacketsLazily(t *testing.T) {
 	}
 }
 
+// mockPacketWriter records every packet written through it, in order.
+// packet is a convenience accessor for the most recently written one.
 type mockPacketWriter struct {
-	packet gopacket.Packet
+	packet  gopacket.Packet
+	packets []gopacket.Packet
 }
 
 func (pw *mockPacketWriter) WritePacketData(bytes []byte) (err error) {
 	decoder := gopacket.DecodersByLayerName["Ethernet"]
 	pw.packet = gopacket.NewPacket(bytes, decoder, gopacket.DecodeOptions{Lazy: true})
+	pw.packets = append(pw.packets, pw.packet)
 	return
 }
 
@@ -297,8 +289,28 @@ func TestSendBonjourPacket(t *testing.T) {
 
 	pw := &mockPacketWriter{packet: nil}
 
-	sendBonjourPacket(pw, &bonjourTestPacket, uint16(29), brMACTest)
-	if !reflect.DeepEqual(initialPacket.Layers(), pw.packet.Layers()) {
-		t.Error("Error in sendBonjourPacket()")
+	sendBonjourPacket(pw, &bonjourTestPacket, uint16(29), brMACTest, defaultMTU)
+	if len(pw.packets) != 1 {
+		t.Fatalf("sendBonjourPacket() wrote %d packets for a packet well under the MTU, want 1", len(pw.packets))
+	}
+
+	// sendBonjourPacket re-serializes with FixLengths and ComputeChecksums,
+	// so the reflected IPv4 header's Length and checksum bytes legitimately
+	// differ from the fixture's hardcoded ones; compare the fields a
+	// receiver of the reflected frame actually cares about instead of the
+	// raw layer bytes.
+	initialIP := initialPacket.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	sentIP := pw.packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	if !sentIP.SrcIP.Equal(initialIP.SrcIP) || !sentIP.DstIP.Equal(initialIP.DstIP) {
+		t.Errorf("sent IPv4 src/dst = %v/%v, want %v/%v", sentIP.SrcIP, sentIP.DstIP, initialIP.SrcIP, initialIP.DstIP)
+	}
+
+	initialUDP := initialPacket.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	sentUDP := pw.packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if sentUDP.SrcPort != initialUDP.SrcPort || sentUDP.DstPort != initialUDP.DstPort {
+		t.Errorf("sent UDP ports = %v/%v, want %v/%v", sentUDP.SrcPort, sentUDP.DstPort, initialUDP.SrcPort, initialUDP.DstPort)
+	}
+	if !reflect.DeepEqual(sentUDP.Payload, initialUDP.Payload) {
+		t.Error("sent UDP payload does not match the original packet's DNS payload")
 	}
 }
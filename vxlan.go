@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// vxlanPort is the IANA-assigned UDP destination port for VXLAN traffic.
+const vxlanPort = layers.UDPPort(4789)
+
+// vxlanPeer describes a remote VTEP that Bonjour traffic tagged with
+// VLANTag should be bridged to over VXLAN, for segments that sit behind
+// an L3 boundary rather than being reachable as a plain 802.1Q trunk.
+type vxlanPeer struct {
+	VLANTag  uint16
+	VNI      uint32
+	RemoteIP net.IP
+	VTEPMAC  net.HardwareAddr
+}
+
+// parseVXLANPeers parses a comma-separated list of
+// "vlan:vni:remoteIP:vtepMAC" entries, as accepted by the -vxlan-peers
+// flag, into a lookup table keyed by VLAN tag.
+func parseVXLANPeers(spec string) (map[uint16]vxlanPeer, error) {
+	peers := make(map[uint16]vxlanPeer)
+	if spec == "" {
+		return peers, nil
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		// SplitN with a cap of 4 so the trailing MAC address, which itself
+		// contains colons, is kept intact as the last field.
+		fields := strings.SplitN(entry, ":", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid -vxlan-peers entry %q: want vlan:vni:remoteIP:vtepMAC", entry)
+		}
+
+		vlanTag, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VLAN tag in %q: %w", entry, err)
+		}
+		vni, err := strconv.ParseUint(fields[1], 10, 24)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VNI in %q: %w", entry, err)
+		}
+		remoteIP := net.ParseIP(fields[2])
+		if remoteIP == nil {
+			return nil, fmt.Errorf("invalid remote VTEP IP in %q", entry)
+		}
+		vtepMAC, err := net.ParseMAC(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid VTEP MAC in %q: %w", entry, err)
+		}
+
+		peers[uint16(vlanTag)] = vxlanPeer{
+			VLANTag:  uint16(vlanTag),
+			VNI:      uint32(vni),
+			RemoteIP: remoteIP,
+			VTEPMAC:  vtepMAC,
+		}
+	}
+
+	return peers, nil
+}
+
+// sendBonjourPacketOverVXLAN wraps bp's packet as
+// Ethernet/IP/UDP/VXLAN/InnerEthernet/InnerIP/InnerUDP/DNS addressed to
+// peer's remote VTEP, and writes the resulting frame out through pw. The
+// inner frame is every serializable layer of bp's packet as it is,
+// including its 802.1Q tag when it has one. This
+// is how Bonjour is bridged between segments that are L3-separated
+// rather than just different VLANs on the same trunk.
+func sendBonjourPacketOverVXLAN(pw packetWriter, bp *bonjourPacket, peer vxlanPeer, localIP net.IP, brMAC net.HardwareAddr) error {
+	outerEthernet := &layers.Ethernet{
+		SrcMAC:       brMAC,
+		DstMAC:       peer.VTEPMAC,
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	outerIP := &layers.IPv4{
+		SrcIP:    localIP,
+		DstIP:    peer.RemoteIP,
+		Version:  4,
+		IHL:      5,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+	}
+	outerUDP := &layers.UDP{
+		SrcPort: vxlanPort,
+		DstPort: vxlanPort,
+	}
+	vxlanLayer := &layers.VXLAN{
+		ValidIDFlag: true,
+		VNI:         peer.VNI,
+	}
+
+	innerLayers := serializableLayers(bp.packet)
+
+	outerLayers := make([]gopacket.SerializableLayer, 0, 4+len(innerLayers))
+	outerLayers = append(outerLayers, outerEthernet, outerIP, outerUDP, vxlanLayer)
+	outerLayers = append(outerLayers, innerLayers...)
+
+	// Every UDP layer in the stack (the outer VXLAN transport and,
+	// assuming the inner frame is IP-based, the original mDNS datagram)
+	// needs its network layer set before ComputeChecksums can touch it;
+	// SerializeLayers has no notion of which IP header pairs with which
+	// UDP header, so we track the most recently seen one ourselves.
+	if err := setUDPChecksumNetworkLayers(outerLayers); err != nil {
+		return err
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buffer, options, outerLayers...); err != nil {
+		return err
+	}
+
+	return pw.WritePacketData(buffer.Bytes())
+}
+
+// setUDPChecksumNetworkLayers walks serializableLayers in order and, for
+// each layers.UDP it finds, associates it with the nearest preceding
+// network layer (an IPv4 or IPv6) for checksum computation.
+func setUDPChecksumNetworkLayers(layerList []gopacket.SerializableLayer) error {
+	var networkLayer gopacket.NetworkLayer
+	for _, layer := range layerList {
+		if nl, ok := layer.(gopacket.NetworkLayer); ok {
+			networkLayer = nl
+			continue
+		}
+		if udp, ok := layer.(*layers.UDP); ok && networkLayer != nil {
+			if err := udp.SetNetworkLayerForChecksum(networkLayer); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// serializableLayers returns the subset of packet's layers that know how
+// to re-serialize themselves, in their original order.
+func serializableLayers(packet gopacket.Packet) []gopacket.SerializableLayer {
+	layerList := packet.Layers()
+	serializable := make([]gopacket.SerializableLayer, 0, len(layerList))
+	for _, layer := range layerList {
+		if s, ok := layer.(gopacket.SerializableLayer); ok {
+			serializable = append(serializable, s)
+		}
+	}
+	return serializable
+}
+
+// decapsulateVXLAN extracts the inner Bonjour frame carried inside a
+// VXLAN-encapsulated packet received from a peer VTEP, or nil if packet
+// does not carry a VXLAN layer.
+func decapsulateVXLAN(packet gopacket.Packet) gopacket.Packet {
+	vxlanLayer := packet.Layer(layers.LayerTypeVXLAN)
+	if vxlanLayer == nil {
+		return nil
+	}
+	decoder := gopacket.DecodersByLayerName["Ethernet"]
+	return gopacket.NewPacket(vxlanLayer.LayerPayload(), decoder, gopacket.DecodeOptions{Lazy: true})
+}
+
+// vxlanIngressStage decapsulates every VXLAN packet read from in and
+// injects the inner Bonjour frame into out, the same packet channel that
+// local interface traffic is read from, so filterBonjourPacketsLazily
+// treats remote and local Bonjour packets identically. Packets that do
+// not carry a VXLAN layer are dropped.
+func vxlanIngressStage(in <-chan gopacket.Packet, out chan<- gopacket.Packet) {
+	go func() {
+		for packet := range in {
+			if inner := decapsulateVXLAN(packet); inner != nil {
+				out <- inner
+			}
+		}
+	}()
+}
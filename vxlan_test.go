@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	vtepMACTest    = net.HardwareAddr{0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF}
+	vtepRemoteTest = net.IP{10, 0, 0, 2}
+	vtepLocalTest  = net.IP{10, 0, 0, 1}
+)
+
+func TestParseVXLANPeers(t *testing.T) {
+	peers, err := parseVXLANPeers("30:5030:10.0.0.2:aa:bb:cc:dd:ee:ff")
+	if err != nil {
+		t.Fatalf("parseVXLANPeers() returned an unexpected error: %v", err)
+	}
+
+	peer, ok := peers[30]
+	if !ok {
+		t.Fatal("parseVXLANPeers() did not register a peer for VLAN 30")
+	}
+	if peer.VNI != 5030 || !peer.RemoteIP.Equal(vtepRemoteTest) || peer.VTEPMAC.String() != vtepMACTest.String() {
+		t.Errorf("parseVXLANPeers() = %+v, want VNI 5030, remote %v, MAC %v", peer, vtepRemoteTest, vtepMACTest)
+	}
+}
+
+func TestSendBonjourPacketOverVXLAN(t *testing.T) {
+	initialData := createMockmDNSPacket(true, true)
+	decoder := gopacket.DecodersByLayerName["Ethernet"]
+	initialPacket := gopacket.NewPacket(initialData, decoder, gopacket.DecodeOptions{Lazy: true})
+	bonjourTestPacket := bonjourPacket{
+		packet:     initialPacket,
+		vlanTag:    &vlanIdentifierTest,
+		srcMAC:     &srcMACTest,
+		dstMAC:     &dstMACTest,
+		isDNSQuery: true,
+	}
+
+	peer := vxlanPeer{VLANTag: vlanIdentifierTest, VNI: 5030, RemoteIP: vtepRemoteTest, VTEPMAC: vtepMACTest}
+
+	pw := &mockPacketWriter{packet: nil}
+	if err := sendBonjourPacketOverVXLAN(pw, &bonjourTestPacket, peer, vtepLocalTest, brMACTest); err != nil {
+		t.Fatalf("sendBonjourPacketOverVXLAN() returned an unexpected error: %v", err)
+	}
+
+	outerEthernetLayer := pw.packet.Layer(layers.LayerTypeEthernet)
+	if outerEthernetLayer == nil {
+		t.Fatal("VXLAN packet has no outer Ethernet layer")
+	}
+	outerEthernet := outerEthernetLayer.(*layers.Ethernet)
+	if outerEthernet.DstMAC.String() != vtepMACTest.String() {
+		t.Errorf("outer Ethernet DstMAC = %v, want %v", outerEthernet.DstMAC, vtepMACTest)
+	}
+	if outerEthernet.SrcMAC.String() != brMACTest.String() {
+		t.Errorf("outer Ethernet SrcMAC = %v, want %v", outerEthernet.SrcMAC, brMACTest)
+	}
+
+	vxlanLayer := pw.packet.Layer(layers.LayerTypeVXLAN)
+	if vxlanLayer == nil {
+		t.Fatal("packet sent by sendBonjourPacketOverVXLAN has no VXLAN layer")
+	}
+	if vxlanLayer.(*layers.VXLAN).VNI != peer.VNI {
+		t.Errorf("VXLAN VNI = %d, want %d", vxlanLayer.(*layers.VXLAN).VNI, peer.VNI)
+	}
+
+	inner := decapsulateVXLAN(pw.packet)
+	if inner == nil {
+		t.Fatal("decapsulateVXLAN() returned nil for a VXLAN-encapsulated packet")
+	}
+
+	// sendBonjourPacketOverVXLAN re-serializes the inner frame with
+	// FixLengths and ComputeChecksums, so its IPv4 Length and checksum
+	// bytes legitimately differ from the fixture's hardcoded ones; compare
+	// the fields a reader of the reflected frame actually cares about
+	// instead of the raw layer bytes.
+	initialIP := initialPacket.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	innerIP := inner.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	if !innerIP.SrcIP.Equal(initialIP.SrcIP) || !innerIP.DstIP.Equal(initialIP.DstIP) {
+		t.Errorf("inner IPv4 src/dst = %v/%v, want %v/%v", innerIP.SrcIP, innerIP.DstIP, initialIP.SrcIP, initialIP.DstIP)
+	}
+
+	initialUDP := initialPacket.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	innerUDP := inner.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if innerUDP.SrcPort != initialUDP.SrcPort || innerUDP.DstPort != initialUDP.DstPort {
+		t.Errorf("inner UDP ports = %v/%v, want %v/%v", innerUDP.SrcPort, innerUDP.DstPort, initialUDP.SrcPort, initialUDP.DstPort)
+	}
+	if !reflect.DeepEqual(innerUDP.Payload, initialUDP.Payload) {
+		t.Error("inner UDP payload does not match the original packet's DNS payload")
+	}
+}